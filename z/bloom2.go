@@ -3,6 +3,8 @@ package z
 import (
 	"encoding/json"
 	"log"
+	"math"
+	"math/bits"
 )
 
 type Bloom2 struct {
@@ -95,6 +97,42 @@ func (bl Bloom2) Has(h uint32) bool {
 	return true
 }
 
+// EstimatedFillRatio returns the fraction of bits set in the filter's
+// bitset. It climbs toward 1 as more keys are added past the filter's
+// design capacity, and is a leading indicator of a rising false-positive
+// rate.
+func (bl Bloom2) EstimatedFillRatio() float64 {
+	f := bl.bitset
+	if len(f) < 2 {
+		return 0
+	}
+	nBits := 8 * (len(f) - 1)
+	set := 0
+	for _, b := range f[:len(f)-1] {
+		set += bits.OnesCount8(b)
+	}
+	return float64(set) / float64(nBits)
+}
+
+// EstimatedCount estimates the number of distinct keys added to the filter
+// so far, using the standard -(nBits/k) * ln(1 - fill) estimator.
+func (bl Bloom2) EstimatedCount() int {
+	f := bl.bitset
+	if len(f) < 2 {
+		return 0
+	}
+	k := f[len(f)-1]
+	if k == 0 || k > 30 {
+		return 0
+	}
+	fill := bl.EstimatedFillRatio()
+	if fill >= 1 {
+		return math.MaxInt32
+	}
+	nBits := float64(8 * (len(f) - 1))
+	return int(-1 * (nBits / float64(k)) * math.Log(1-fill))
+}
+
 func (bl Bloom2) JSONMarshal() []byte {
 	data, err := json.Marshal(bl.bitset)
 	if err != nil {
@@ -19,10 +19,8 @@ package z
 import (
 	"encoding/binary"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
-	"os"
 	"sort"
 
 	"github.com/pkg/errors"
@@ -42,9 +40,23 @@ type Buffer struct {
 	offset        int64
 	curSz         int64
 	maxSz         int64
-	fd            *os.File
+	storage       BufferStorage // only set in UseMmap mode, see storage.go.
 	bufType       BufferType
 	autoMmapAfter int64
+	readPos       int64 // read cursor used by Read and Seek.
+
+	// fileSize tracks how far the backing storage has been truncated to, in
+	// UseMmap mode. It only ever grows.
+	fileSize int64
+
+	// The following fields are only used when opts.Compression is enabled.
+	// See compress.go. Compression always happens in place (blocks never
+	// move past curSz), so it needs no extra bookkeeping against maxSz.
+	opts         BufferOptions
+	blocks       []blockMeta
+	compressedTo int64 // logical offset up to which writes have been compressed.
+	cache        *blockCache
+	stats        BufferStats
 }
 
 type BufferType int
@@ -78,18 +90,21 @@ func NewBuffer(sz int64) *Buffer {
 }
 
 func (b *Buffer) doMmap() error {
+	if b.storage == nil {
+		s, err := NewTempFileStorage()
+		if err != nil {
+			return err
+		}
+		b.storage = s
+	}
+
 	curBuf := b.buf
-	fd, err := ioutil.TempFile("", "buffer")
-	if err != nil {
+	if err := b.storage.Truncate(b.curSz); err != nil {
 		return err
 	}
-	if err := fd.Truncate(int64(b.curSz)); err != nil {
-		return errors.Wrapf(err, "while truncating %s to size: %d", fd.Name(), b.curSz)
-	}
-
-	buf, err := Mmap(fd, true, b.maxSz) // Mmap up to max size.
+	buf, err := b.storage.MapRegion(0, b.maxSz) // Map up to max size.
 	if err != nil {
-		return errors.Wrapf(err, "while mmapping %s with size: %d", fd.Name(), b.maxSz)
+		return err
 	}
 	if len(curBuf) > 0 {
 		assert(b.offset == int64(copy(buf, curBuf[:b.offset])))
@@ -97,7 +112,20 @@ func (b *Buffer) doMmap() error {
 	}
 	b.buf = buf
 	b.bufType = UseMmap
-	b.fd = fd
+	b.fileSize = b.curSz
+	return nil
+}
+
+// ensureFileSize grows the backing storage up to at least n bytes. It never
+// shrinks it: a call with n at or below the current file size is a no-op.
+func (b *Buffer) ensureFileSize(n int64) error {
+	if n <= b.fileSize {
+		return nil
+	}
+	if err := b.storage.Truncate(n); err != nil {
+		return err
+	}
+	b.fileSize = n
 	return nil
 }
 
@@ -134,6 +162,32 @@ func NewBufferWith(sz, maxSz int64, bufType BufferType) (*Buffer, error) {
 	return b, nil
 }
 
+// NewBufferWithStorage is like NewBufferWith with bufType UseMmap, except it
+// spills into s instead of the default TempFileStorage. This lets callers
+// plug in their own region provider (huge pages, a specific tmpfs mount,
+// MemfdStorage, ...) without forking Buffer.
+func NewBufferWithStorage(sz, maxSz int64, s BufferStorage) (*Buffer, error) {
+	if sz == 0 {
+		sz = smallBufferSize
+	}
+	if maxSz == 0 {
+		maxSz = math.MaxInt32
+	}
+
+	b := &Buffer{
+		offset:  1,
+		curSz:   sz,
+		maxSz:   maxSz,
+		storage: s,
+	}
+	if err := b.doMmap(); err != nil {
+		return nil, err
+	}
+
+	b.buf[0] = 0x00
+	return b, nil
+}
+
 func (b *Buffer) IsEmpty() bool {
 	return b.offset == 1
 }
@@ -192,10 +246,11 @@ func (b *Buffer) Grow(n int64) {
 			b.buf = newBuf
 		}
 	case UseMmap:
-		if err := b.fd.Truncate(b.curSz); err != nil {
-			log.Fatalf("While trying to truncate file %s to size: %d error: %v\n",
-				b.fd.Name(), b.curSz, err)
-		}
+		check(b.ensureFileSize(b.curSz))
+	}
+
+	if b.opts.Compression != None && b.autoMmapAfter > 0 && b.curSz > b.autoMmapAfter {
+		check(b.Compress())
 	}
 }
 
@@ -356,12 +411,23 @@ func (s *sortHelper) sort(lo, hi int) []byte {
 }
 
 // SortSlice is like SortSliceBetween but sorting over the entire buffer.
-func (b *Buffer) SortSlice(less func(left, right []byte) bool) {
-	b.SortSliceBetween(1, b.offset, less)
+func (b *Buffer) SortSlice(less func(left, right []byte) bool) error {
+	return b.SortSliceBetween(1, b.offset, less)
 }
-func (b *Buffer) SortSliceBetween(start, end int64, less LessFunc) {
+
+// SortSliceBetween sorts the length-prefixed slices between start and end.
+// It sorts by moving raw bytes around directly, rather than going through
+// Slice/readAt, so it can't be used on a Buffer that has any compressed
+// blocks: it would reinterpret compressed bytes as raw length-prefixed
+// slices, and overwrite them in place, corrupting the block index. Call
+// SortSliceBetween (or SortSlice) before ever calling Compress, or not at
+// all on a Buffer Compress has touched.
+func (b *Buffer) SortSliceBetween(start, end int64, less LessFunc) error {
+	if b.compressedTo > 0 {
+		return errors.Errorf("z: cannot sort a Buffer that has compressed blocks")
+	}
 	if start >= end {
-		return
+		return nil
 	}
 	if start == 0 {
 		panic("start can never be zero")
@@ -397,6 +463,7 @@ func (b *Buffer) SortSliceBetween(start, end int64, less LessFunc) {
 		left = off
 	}
 	s.sort(0, len(offsets)-1)
+	return nil
 }
 
 func rawSlice(buf []byte) []byte {
@@ -410,10 +477,24 @@ func (b *Buffer) Slice(offset int64) ([]byte, int64) {
 		return nil, 0
 	}
 
-	sz := binary.BigEndian.Uint32(b.buf[offset:])
+	// Fast path: offset lies in the uncompressed tail, so we can return a
+	// slice of b.buf directly without copying.
+	if offset >= b.compressedTo {
+		sz := binary.BigEndian.Uint32(b.buf[offset:])
+		start := offset + 4
+		next := start + int64(sz)
+		res := b.buf[start:next]
+		if next >= b.offset {
+			next = 0
+		}
+		return res, next
+	}
+
+	lenBuf := b.readAt(offset, 4)
+	sz := int64(binary.BigEndian.Uint32(lenBuf))
 	start := offset + 4
-	next := start + int64(sz)
-	res := b.buf[start:next]
+	next := start + sz
+	res := b.readAt(start, sz)
 	if next >= b.offset {
 		next = 0
 	}
@@ -459,18 +540,11 @@ func (b *Buffer) Release() error {
 		Free(b.buf)
 
 	case UseMmap:
-		fname := b.fd.Name()
-		if err := Munmap(b.buf); err != nil {
-			return errors.Wrapf(err, "while munmap file %s", fname)
-		}
-		if err := b.fd.Truncate(0); err != nil {
-			return errors.Wrapf(err, "while truncating file %s", fname)
-		}
-		if err := b.fd.Close(); err != nil {
-			return errors.Wrapf(err, "while closing file %s", fname)
+		if err := b.storage.Unmap(b.buf); err != nil {
+			return errors.Wrap(err, "while unmapping buffer")
 		}
-		if err := os.Remove(b.fd.Name()); err != nil {
-			return errors.Wrapf(err, "while deleting file %s", fname)
+		if err := b.storage.Close(); err != nil {
+			return errors.Wrap(err, "while closing buffer storage")
 		}
 	}
 	return nil
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// BufferPool hands out *Buffer instances backed by sync.Pool, bucketed by
+// power-of-two capacity. Recycling Buffers this way avoids the repeated
+// Calloc/mmap + Free churn that shows up when a lot of short-lived Buffers
+// get used for things like slice sorting or sstable building.
+//
+// A Buffer returned via Put is Reset so it comes back clean on the next Get.
+// If, at the time of Put, the Buffer's capacity exceeds maxRetain, the
+// underlying storage is released instead of being pooled, so a handful of
+// oversized Buffers don't end up pinned in the pool forever.
+type BufferPool struct {
+	maxRetain int64
+
+	mu      sync.RWMutex
+	classes []*sync.Pool
+}
+
+// NewPool creates a BufferPool. maxRetain caps the capacity of Buffers that
+// get retained by Put; set it to 0 to retain Buffers of any size.
+func NewPool(maxRetain int64) *BufferPool {
+	return &BufferPool{maxRetain: maxRetain}
+}
+
+// classFor returns the bucket index for a request of sz bytes, along with
+// the power-of-two capacity that bucket serves: the smallest power of two
+// that is >= sz. Used by Get, where sz is how much the caller is asking for.
+func classFor(sz int64) (int, int64) {
+	if sz < smallBufferSize {
+		sz = smallBufferSize
+	}
+	capacity := int64(1) << bits.Len64(uint64(sz-1))
+	idx := bits.Len64(uint64(capacity)) - 1
+	return idx, capacity
+}
+
+// classForCapacity returns the bucket index a Buffer backed by capacity
+// bytes may safely be pooled under: the largest power of two that is <=
+// capacity. Used by Put, where capacity is how much the Buffer actually has
+// (len(b.buf)), which Grow can leave at any value, not just a power of two.
+// Flooring (rather than classFor's rounding up) guarantees every Buffer
+// pooled under bucket idx backs at least 2^idx bytes, so a later
+// Get(2^idx) can't be handed back fewer bytes than it asked for.
+func classForCapacity(capacity int64) int {
+	if capacity < smallBufferSize {
+		capacity = smallBufferSize
+	}
+	return bits.Len64(uint64(capacity)) - 1
+}
+
+// pool returns the sync.Pool for idx, growing p.classes if necessary.
+func (p *BufferPool) pool(idx int) *sync.Pool {
+	p.mu.RLock()
+	if idx < len(p.classes) {
+		pl := p.classes[idx]
+		p.mu.RUnlock()
+		return pl
+	}
+	p.mu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for idx >= len(p.classes) {
+		p.classes = append(p.classes, new(sync.Pool))
+	}
+	return p.classes[idx]
+}
+
+// Get returns a Buffer with at least sz bytes of capacity, reusing a pooled
+// one if available. The returned Buffer is in UseCalloc mode and must be
+// handed back via Put once the caller is done with it.
+func (p *BufferPool) Get(sz int64) *Buffer {
+	idx, capacity := classFor(sz)
+	if v := p.pool(idx).Get(); v != nil {
+		return v.(*Buffer)
+	}
+	return NewBuffer(capacity)
+}
+
+// Put resets b and returns it to the pool, unless its capacity exceeds
+// maxRetain or it has crossed into UseMmap mode, in which case b is released
+// instead of retained. A pooled UseMmap Buffer would hold its open temp-file
+// fd and mapping alive for as long as sync.Pool happens to keep the entry
+// around, with nothing to notice (let alone close them) if sync.Pool drops
+// it under memory pressure instead of handing it back out — so those never
+// get pooled, regardless of how small curSz has shrunk back down to.
+func (p *BufferPool) Put(b *Buffer) {
+	if b == nil {
+		return
+	}
+	if b.bufType == UseMmap || (p.maxRetain > 0 && b.curSz > p.maxRetain) {
+		check(b.Release())
+		return
+	}
+	b.Reset()
+	idx := classForCapacity(int64(len(b.buf)))
+	p.pool(idx).Put(b)
+}
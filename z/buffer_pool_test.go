@@ -0,0 +1,127 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import "testing"
+
+func TestClassFor(t *testing.T) {
+	cases := []struct {
+		sz      int64
+		wantCap int64
+	}{
+		{0, smallBufferSize},
+		{1, smallBufferSize},
+		{smallBufferSize, smallBufferSize},
+		{smallBufferSize + 1, smallBufferSize * 2},
+		{100, 128},
+		{1000, 1024},
+	}
+	for _, tc := range cases {
+		_, cap := classFor(tc.sz)
+		if cap != tc.wantCap {
+			t.Errorf("classFor(%d) capacity = %d, want %d", tc.sz, cap, tc.wantCap)
+		}
+	}
+}
+
+func TestClassForCapacity(t *testing.T) {
+	cases := []struct {
+		capacity int64
+		wantIdx  int
+	}{
+		{0, 6}, // floors up to smallBufferSize (64) == 2^6.
+		{64, 6},
+		{100, 6}, // floors down to 64, not up to 128.
+		{128, 7},
+		{228, 7}, // floors down to 128, not up to 256.
+		{256, 8},
+	}
+	for _, tc := range cases {
+		if got := classForCapacity(tc.capacity); got != tc.wantIdx {
+			t.Errorf("classForCapacity(%d) = %d, want %d", tc.capacity, got, tc.wantIdx)
+		}
+	}
+}
+
+func TestBufferPoolReusesBuffer(t *testing.T) {
+	p := NewPool(0)
+	b := p.Get(128)
+	b.Write([]byte("hello"))
+	ptr := &b.buf[0]
+	p.Put(b)
+
+	b2 := p.Get(128)
+	if &b2.buf[0] != ptr {
+		t.Fatalf("expected Get to reuse the pooled buffer's backing array")
+	}
+	if !b2.IsEmpty() {
+		t.Fatalf("expected pooled buffer to come back Reset")
+	}
+	p.Put(b2)
+}
+
+func TestBufferPoolReleasesOversizedBuffer(t *testing.T) {
+	p := NewPool(64)
+	b := p.Get(1024)
+	p.Put(b) // curSz exceeds maxRetain, so b should be released, not pooled.
+
+	b2 := p.Get(1024)
+	if b2 == b {
+		t.Fatalf("expected an oversized buffer not to be pooled")
+	}
+	p.Put(b2)
+}
+
+// TestBufferPoolPutBucketsByActualCapacity is a regression test: Put used
+// to bucket a Buffer by classFor(b.curSz), which rounds curSz up to the
+// next power of two. Once Grow left curSz at a non-power-of-two value, that
+// rounded-up bucket overstated the Buffer's actual capacity, so a later Get
+// for that bucket's size could be handed back fewer bytes than it asked for.
+func TestBufferPoolPutBucketsByActualCapacity(t *testing.T) {
+	p := NewPool(0)
+	b := p.Get(64)
+	if _, err := b.Write(make([]byte, 100)); err != nil { // grows curSz to a non-power-of-two.
+		t.Fatalf("Write: %v", err)
+	}
+	p.Put(b)
+
+	b2 := p.Get(256)
+	if int64(len(b2.buf)) < 256 {
+		t.Fatalf("Get(256) returned a Buffer with only %d bytes of capacity, want at least 256",
+			len(b2.buf))
+	}
+	p.Put(b2)
+}
+
+// TestBufferPoolReleasesMmapBuffer is a regression test: Put used to decide
+// whether to pool a Buffer purely by comparing curSz against maxRetain,
+// which let a small UseMmap Buffer (with an open fd and mapping) get cached
+// in the pool instead of released.
+func TestBufferPoolReleasesMmapBuffer(t *testing.T) {
+	s := &memStorage{}
+	b, err := NewBufferWithStorage(64, 1<<20, s)
+	if err != nil {
+		t.Fatalf("NewBufferWithStorage: %v", err)
+	}
+
+	p := NewPool(0)
+	p.Put(b)
+
+	if !s.closed {
+		t.Fatalf("expected Put to Release (and so Close) a UseMmap buffer rather than pool it")
+	}
+}
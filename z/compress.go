@@ -0,0 +1,298 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+// Compression picks the algorithm used to compress a Buffer's spilled
+// content. Only Snappy is supported today; None disables compression
+// entirely.
+type Compression int
+
+const (
+	None Compression = iota
+	Snappy
+)
+
+// defaultBlockSize is used whenever BufferOptions.BlockSize is left at zero.
+const defaultBlockSize = 64 << 10
+
+// defaultDecompressCacheBytes is used whenever
+// BufferOptions.DecompressCacheBytes is left at zero.
+const defaultDecompressCacheBytes = 4 * defaultBlockSize
+
+// BufferOptions controls whether and how a Buffer compresses its spilled
+// (UseMmap) content. It's only meaningful for UseMmap buffers; passing a
+// Compression other than None for a UseCalloc buffer is an error.
+type BufferOptions struct {
+	// Compression selects the algorithm used when writes are compressed,
+	// either via Compress or automatically once AutoMmapAfter is crossed.
+	Compression Compression
+
+	// BlockSize is the size of the fixed-size chunks the written region is
+	// split into before compression. Defaults to 64 KiB.
+	BlockSize int64
+
+	// DecompressCacheBytes bounds the size of the LRU cache of decompressed
+	// blocks kept around to avoid re-decompressing the same block
+	// repeatedly, e.g. across nearby Slice calls during a SliceIterate pass.
+	// Defaults to 256 KiB (four blocks of the default 64 KiB size), whatever
+	// BlockSize is set to.
+	DecompressCacheBytes int64
+}
+
+// blockMeta indexes a single compressed block: the logical, uncompressed
+// offset range it covers, and where its compressed bytes live. Blocks are
+// compressed in place (fileOffset == startOffset): the compressed form
+// always fits inside the span the uncompressed block already occupied, so
+// Compress never grows the backing file. If compression didn't actually
+// shrink the block (e.g. incompressible data), raw is set and the original
+// bytes are kept as-is rather than spending extra space on a compressed
+// copy that wouldn't fit.
+type blockMeta struct {
+	startOffset     int64 // inclusive, in the uncompressed logical offset space.
+	endOffset       int64 // exclusive.
+	fileOffset      int64
+	compressedLen   int64
+	uncompressedLen int64
+	raw             bool
+}
+
+// BufferStats reports how much of a Buffer's content has been compressed.
+type BufferStats struct {
+	CompressedBytes   int64
+	UncompressedBytes int64
+	Blocks            int
+}
+
+// NewBufferWithOptions is like NewBufferWith, but additionally takes
+// BufferOptions to control compressed spilling. Compression is only valid
+// for UseMmap buffers.
+func NewBufferWithOptions(sz, maxSz int64, bufType BufferType, opts BufferOptions) (*Buffer, error) {
+	if opts.Compression != None && bufType != UseMmap {
+		return nil, errors.Errorf("z: compression requires a UseMmap buffer")
+	}
+	b, err := NewBufferWith(sz, maxSz, bufType)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = defaultBlockSize
+	}
+	b.opts = opts
+	if opts.Compression != None {
+		b.cache = newBlockCache(opts.DecompressCacheBytes)
+	}
+	return b, nil
+}
+
+// Compress chunks the already-written region of b into BlockSize blocks and
+// compresses each of them in place with the configured algorithm. It's
+// called automatically from Grow once curSz crosses AutoMmapAfter, but can
+// also be called explicitly to force spilling, e.g. once b is done being
+// written to and about to sit idle for a while.
+//
+// Compress must not be called on a Buffer that SortSlice/SortSliceBetween
+// will still be sorting: sorting moves raw bytes around directly and has no
+// way to account for a block index laid on top of them. Sort first, then
+// compress.
+//
+// Compression never grows the backing file: a block is only rewritten as
+// its compressed form if that form is strictly smaller, so a compressed
+// Buffer never ends up using more backing storage than an uncompressed one
+// would have.
+//
+// Any trailing partial block (smaller than BlockSize) is left uncompressed;
+// it'll be picked up by a later Compress call once more data lands after it.
+func (b *Buffer) Compress() error {
+	if b.opts.Compression == None {
+		return nil
+	}
+	if b.bufType != UseMmap {
+		return errors.Errorf("z: compression requires a UseMmap buffer")
+	}
+
+	blockSize := b.opts.BlockSize
+	numBlocks := (b.offset - b.compressedTo) / blockSize
+	end := b.compressedTo + numBlocks*blockSize
+
+	for start := b.compressedTo; start < end; start += blockSize {
+		uncompressed := b.buf[start : start+blockSize]
+
+		var compressed []byte
+		switch b.opts.Compression {
+		case Snappy:
+			compressed = snappy.Encode(nil, uncompressed)
+		default:
+			return errors.Errorf("z: unknown compression type %d", b.opts.Compression)
+		}
+
+		meta := blockMeta{
+			startOffset:     start,
+			endOffset:       start + blockSize,
+			fileOffset:      start,
+			uncompressedLen: blockSize,
+		}
+		if int64(len(compressed)) < blockSize {
+			copy(b.buf[start:], compressed)
+			meta.compressedLen = int64(len(compressed))
+			b.stats.CompressedBytes += int64(len(compressed))
+		} else {
+			// Compression didn't actually shrink this block; leave the
+			// original bytes in place rather than writing a same-size or
+			// larger "compressed" copy.
+			meta.compressedLen = blockSize
+			meta.raw = true
+			b.stats.CompressedBytes += blockSize
+		}
+
+		b.blocks = append(b.blocks, meta)
+		b.stats.UncompressedBytes += blockSize
+		b.stats.Blocks++
+	}
+	b.compressedTo = end
+	return nil
+}
+
+// Stats returns a snapshot of b's compression statistics.
+func (b *Buffer) Stats() BufferStats {
+	return b.stats
+}
+
+// blockFor returns the blockMeta covering logical offset off, or nil if off
+// falls outside the compressed region (e.g. in the uncompressed tail).
+func (b *Buffer) blockFor(off int64) *blockMeta {
+	i := sort.Search(len(b.blocks), func(i int) bool { return b.blocks[i].endOffset > off })
+	if i < len(b.blocks) && b.blocks[i].startOffset <= off {
+		return &b.blocks[i]
+	}
+	return nil
+}
+
+// decompress returns the decompressed bytes for blk, consulting (and
+// populating) b.cache first.
+func (b *Buffer) decompress(blk *blockMeta) []byte {
+	if blk.raw {
+		return b.buf[blk.fileOffset : blk.fileOffset+blk.uncompressedLen]
+	}
+	if dec, ok := b.cache.get(blk.startOffset); ok {
+		return dec
+	}
+
+	compressed := b.buf[blk.fileOffset : blk.fileOffset+blk.compressedLen]
+	var dec []byte
+	var err error
+	switch b.opts.Compression {
+	case Snappy:
+		dec, err = snappy.Decode(nil, compressed)
+	default:
+		err = errors.Errorf("z: unknown compression type %d", b.opts.Compression)
+	}
+	check(err)
+
+	b.cache.put(blk.startOffset, dec)
+	return dec
+}
+
+// readAt returns n logical bytes starting at offset off, transparently
+// decompressing any compressed blocks that range touches.
+func (b *Buffer) readAt(off, n int64) []byte {
+	if off >= b.compressedTo {
+		return b.buf[off : off+n]
+	}
+
+	out := make([]byte, 0, n)
+	for int64(len(out)) < n {
+		cur := off + int64(len(out))
+		blk := b.blockFor(cur)
+		if blk == nil {
+			// We've walked past the compressed region into the raw tail.
+			rem := n - int64(len(out))
+			out = append(out, b.buf[cur:cur+rem]...)
+			break
+		}
+		dec := b.decompress(blk)
+		out = append(out, dec[cur-blk.startOffset:]...)
+	}
+	return out[:n]
+}
+
+// blockCache is a byte-budgeted LRU cache of decompressed blocks, keyed by
+// their logical start offset.
+type blockCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[int64]*list.Element
+}
+
+type cacheEntry struct {
+	key  int64
+	data []byte
+}
+
+func newBlockCache(maxBytes int64) *blockCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultDecompressCacheBytes
+	}
+	return &blockCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[int64]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (c *blockCache) put(key int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*cacheEntry).data = data
+		return
+	}
+
+	e := c.ll.PushFront(&cacheEntry{key: key, data: data})
+	c.items[key] = e
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes && c.ll.Len() > 1 {
+		back := c.ll.Back()
+		c.ll.Remove(back)
+		ce := back.Value.(*cacheEntry)
+		delete(c.items, ce.key)
+		c.size -= int64(len(ce.data))
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// newTestCompressedBuffer returns a UseMmap Buffer, backed by an in-memory
+// memStorage rather than a real temp file, with Snappy compression enabled
+// at the given block size.
+func newTestCompressedBuffer(t *testing.T, blockSize int64) *Buffer {
+	t.Helper()
+	b, err := NewBufferWithStorage(0, 1<<20, &memStorage{})
+	if err != nil {
+		t.Fatalf("NewBufferWithStorage: %v", err)
+	}
+	b.opts = BufferOptions{Compression: Snappy, BlockSize: blockSize}
+	b.cache = newBlockCache(0)
+	return b
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	b := newTestCompressedBuffer(t, 256)
+	defer b.Release()
+
+	payload := bytes.Repeat([]byte("ab"), 50) // 100 compressible bytes.
+	var want [][]byte
+	for i := 0; i < 20; i++ {
+		b.WriteSlice(payload)
+		want = append(want, payload)
+	}
+
+	fsBefore := b.fileSize
+	if err := b.Compress(); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if b.fileSize != fsBefore {
+		t.Fatalf("Compress grew the backing file: before=%d after=%d", fsBefore, b.fileSize)
+	}
+
+	stats := b.Stats()
+	if stats.Blocks == 0 {
+		t.Fatalf("expected Compress to have compressed at least one block")
+	}
+	if stats.CompressedBytes >= stats.UncompressedBytes {
+		t.Fatalf("expected compression to shrink compressible data: compressed=%d uncompressed=%d",
+			stats.CompressedBytes, stats.UncompressedBytes)
+	}
+
+	i := 0
+	err := b.SliceIterate(func(slice []byte) error {
+		if !bytes.Equal(slice, want[i]) {
+			t.Fatalf("slice %d = %q, want %q", i, slice, want[i])
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SliceIterate: %v", err)
+	}
+	if i != len(want) {
+		t.Fatalf("got %d slices, want %d", i, len(want))
+	}
+}
+
+func TestCompressIncompressibleFallsBackToRaw(t *testing.T) {
+	b := newTestCompressedBuffer(t, 256)
+	defer b.Release()
+
+	rnd := rand.New(rand.NewSource(1))
+	var payload []byte
+	for i := 0; i < 10; i++ {
+		// A fresh random payload each time, so blocks can't compress by
+		// picking up repeats across writes.
+		p := make([]byte, 200)
+		rnd.Read(p)
+		b.WriteSlice(p)
+		if i == 0 {
+			payload = p
+		}
+	}
+
+	if err := b.Compress(); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if len(b.blocks) == 0 {
+		t.Fatalf("expected at least one block to have been compressed")
+	}
+	for _, blk := range b.blocks {
+		if !blk.raw {
+			t.Fatalf("expected incompressible block to fall back to raw storage")
+		}
+		if blk.compressedLen != blk.uncompressedLen {
+			t.Fatalf("raw block should keep its original size: compressedLen=%d uncompressedLen=%d",
+				blk.compressedLen, blk.uncompressedLen)
+		}
+	}
+
+	slice, _ := b.Slice(1)
+	if !bytes.Equal(slice, payload) {
+		t.Fatalf("raw block round-trip mismatch")
+	}
+}
+
+// TestSortSliceRejectsCompressedBuffer is a regression test: SortSlice moves
+// raw bytes around directly, bypassing the block index, so it must refuse a
+// Buffer that Compress has touched rather than corrupting it.
+func TestSortSliceRejectsCompressedBuffer(t *testing.T) {
+	b := newTestCompressedBuffer(t, 256)
+	defer b.Release()
+
+	payload := bytes.Repeat([]byte("ab"), 50)
+	for i := 0; i < 20; i++ {
+		b.WriteSlice(payload)
+	}
+	if err := b.Compress(); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+
+	err := b.SortSlice(func(left, right []byte) bool { return bytes.Compare(left, right) < 0 })
+	if err == nil {
+		t.Fatalf("SortSlice on a compressed Buffer = nil error, want an error")
+	}
+}
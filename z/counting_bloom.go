@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// counterBits is the width of each cell in a CountingBloom, packed two to a
+// byte. 4 bits (max count of 15 per cell) is the usual choice for a counting
+// Bloom filter: wide enough that counter overflow is rare at normal
+// occupancy, at the cost of a filter four times the size of a standard
+// one-bit-per-cell bitset.
+const counterBits = 4
+const counterMax = 1<<counterBits - 1
+
+// CountingBloom is a Bloom filter variant that replaces the bit array with a
+// fixed-width counter array, so that keys can be removed again. It's meant
+// for use inside an admission policy (e.g. TinyLFU) that needs to age out
+// old fingerprints rather than grow a filter forever.
+type CountingBloom struct {
+	counters []byte // counterBits-wide counters, two per byte.
+	nBits    uint32
+	k        uint32
+}
+
+// NewCountingBloomFilter returns a CountingBloom sized for numEntries keys,
+// using the same bits-per-key budget (10) and derived k as Bloom2.
+func NewCountingBloomFilter(numEntries int) *CountingBloom {
+	bitsPerKey := 10
+	k := uint32(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+
+	nBits := numEntries * bitsPerKey
+	if nBits < 64 {
+		nBits = 64
+	}
+	nBytes := (nBits + 7) / 8
+	nBits = nBytes * 8
+
+	return &CountingBloom{
+		counters: make([]byte, (nBits+1)/2),
+		nBits:    uint32(nBits),
+		k:        k,
+	}
+}
+
+func (cb *CountingBloom) get(i uint32) uint8 {
+	b := cb.counters[i/2]
+	if i%2 == 0 {
+		return b & 0x0F
+	}
+	return b >> 4
+}
+
+func (cb *CountingBloom) set(i uint32, v uint8) {
+	idx := i / 2
+	if i%2 == 0 {
+		cb.counters[idx] = (cb.counters[idx] &^ 0x0F) | (v & 0x0F)
+	} else {
+		cb.counters[idx] = (cb.counters[idx] &^ 0xF0) | (v << 4)
+	}
+}
+
+// positions returns the k counter cells hash maps to, using the same
+// double-hashing scheme as Bloom2.
+func (cb *CountingBloom) positions(hash uint32) []uint32 {
+	pos := make([]uint32, cb.k)
+	delta := hash>>17 | hash<<15
+	for j := uint32(0); j < cb.k; j++ {
+		pos[j] = hash % cb.nBits
+		hash += delta
+	}
+	return pos
+}
+
+// Add increments the k cell counters for hash. A counter already at its max
+// value is left alone rather than wrapping around.
+func (cb *CountingBloom) Add(hash uint32) {
+	for _, p := range cb.positions(hash) {
+		if v := cb.get(p); v < counterMax {
+			cb.set(p, v+1)
+		}
+	}
+}
+
+func (cb *CountingBloom) AddIfNotHas(hash uint32) bool {
+	if cb.Has(hash) {
+		return false
+	}
+	cb.Add(hash)
+	return true
+}
+
+// Remove decrements the k cell counters for hash and returns true. It
+// returns false, leaving the filter unchanged, if decrementing any of them
+// would underflow it past zero, which means hash wasn't actually present.
+func (cb *CountingBloom) Remove(hash uint32) bool {
+	pos := cb.positions(hash)
+	// A cell can appear more than once among the k positions, and is then
+	// decremented once per appearance.
+	need := make(map[uint32]uint8, len(pos))
+	for _, p := range pos {
+		need[p]++
+	}
+	for p, c := range need {
+		if cb.get(p) < c {
+			return false
+		}
+	}
+	for _, p := range pos {
+		cb.set(p, cb.get(p)-1)
+	}
+	return true
+}
+
+func (cb *CountingBloom) Has(hash uint32) bool {
+	for _, p := range cb.positions(hash) {
+		if cb.get(p) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type countingBloomJSON struct {
+	Counters []byte
+	NBits    uint32
+	K        uint32
+}
+
+func (cb *CountingBloom) JSONMarshal() []byte {
+	data, err := json.Marshal(countingBloomJSON{
+		Counters: cb.counters,
+		NBits:    cb.nBits,
+		K:        cb.k,
+	})
+	if err != nil {
+		log.Fatal("json.Marshal failed: ", err)
+	}
+	return data
+}
+
+func JSONUnmarshalCountingBloom(data []byte) (*CountingBloom, error) {
+	var cj countingBloomJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return nil, err
+	}
+	return &CountingBloom{counters: cj.Counters, nBits: cj.NBits, k: cj.K}, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import "testing"
+
+func TestCountingBloomAddHasRemove(t *testing.T) {
+	cb := NewCountingBloomFilter(1000)
+
+	var added []uint32
+	for i := uint32(0); i < 500; i += 3 {
+		cb.Add(i)
+		added = append(added, i)
+	}
+
+	for _, h := range added {
+		if !cb.Has(h) {
+			t.Fatalf("Has(%d) = false, want true", h)
+		}
+	}
+
+	for _, h := range added {
+		if !cb.Remove(h) {
+			t.Fatalf("Remove(%d) = false, want true", h)
+		}
+	}
+	for _, h := range added {
+		if cb.Has(h) {
+			t.Fatalf("Has(%d) = true after removing every added key, want false", h)
+		}
+	}
+}
+
+func TestCountingBloomDoubleRemoveReturnsFalse(t *testing.T) {
+	cb := NewCountingBloomFilter(1000)
+	cb.Add(42)
+
+	if !cb.Remove(42) {
+		t.Fatalf("first Remove(42) = false, want true")
+	}
+	if cb.Remove(42) {
+		t.Fatalf("second Remove(42) = true, want false (already removed)")
+	}
+}
+
+func TestCountingBloomAddIfNotHas(t *testing.T) {
+	cb := NewCountingBloomFilter(1000)
+
+	if !cb.AddIfNotHas(7) {
+		t.Fatalf("AddIfNotHas(7) = false on first call, want true")
+	}
+	if cb.AddIfNotHas(7) {
+		t.Fatalf("AddIfNotHas(7) = true on second call, want false")
+	}
+}
+
+func TestCountingBloomCounterSaturatesAtMax(t *testing.T) {
+	cb := NewCountingBloomFilter(10)
+
+	// Add far more times than counterMax to push every cell hash touches
+	// past saturation, then confirm Remove can still walk it back down
+	// without underflowing past zero or panicking.
+	for i := 0; i < counterMax+10; i++ {
+		cb.Add(1)
+	}
+	if !cb.Has(1) {
+		t.Fatalf("Has(1) = false after saturating Add, want true")
+	}
+	for i := 0; i < counterMax; i++ {
+		if !cb.Remove(1) {
+			t.Fatalf("Remove(1) call %d = false, want true", i)
+		}
+	}
+	if cb.Remove(1) {
+		t.Fatalf("expected Remove(1) to fail once every counter cell has decremented to zero")
+	}
+}
+
+func TestCountingBloomJSONRoundTrip(t *testing.T) {
+	cb := NewCountingBloomFilter(1000)
+	var added []uint32
+	for i := uint32(0); i < 300; i += 5 {
+		cb.Add(i)
+		added = append(added, i)
+	}
+
+	data := cb.JSONMarshal()
+	got, err := JSONUnmarshalCountingBloom(data)
+	if err != nil {
+		t.Fatalf("JSONUnmarshalCountingBloom: %v", err)
+	}
+	for _, h := range added {
+		if !got.Has(h) {
+			t.Fatalf("after round-trip, Has(%d) = false, want true", h)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// writeToChunkSize is how much WriteTo hands to the destination writer per
+// Write call: 4 MiB, i.e. 1024 4 KiB pages.
+const writeToChunkSize = 4 << 20
+
+// ReadAt implements io.ReaderAt over the bytes written so far, with any
+// compressed blocks (see Compress) transparently decompressed. Outside the
+// compressed region it reads straight from b.buf, without an intermediate copy.
+// Buffer always maps its full storage up front (see doMmap), so in UseMmap
+// mode every written byte already lives in b.buf; there's never a range
+// that needs to be faulted in from the backing file separately.
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("z: ReadAt: negative offset %d", off)
+	}
+	size := b.offset - 1
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	bufOff := off + 1
+	avail := size - off
+	n := int64(len(p))
+	var err error
+	if n > avail {
+		// p asked for more than is available: this read, unlike a plain
+		// Read, must say so via io.EOF rather than silently short-reading.
+		n = avail
+		err = io.EOF
+	}
+
+	copy(p[:n], b.readAt(bufOff, n))
+	return int(n), err
+}
+
+// Read implements io.Reader, reading sequentially from b's own read cursor.
+// It shares no state with NewReader's BufferReaders.
+func (b *Buffer) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.readPos)
+	b.readPos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker over b's own read cursor.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	abs, err := seekAbs(b.readPos, b.offset-1, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	b.readPos = abs
+	return abs, nil
+}
+
+// WriteTo implements io.WriterTo, streaming the written content out in
+// writeToChunkSize chunks. b.buf is already fully resident (see ReadAt), so
+// this mainly avoids handing io.Copy's generic path one giant []byte.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	size := b.offset - 1
+	var written int64
+
+	for written < size {
+		n := int64(writeToChunkSize)
+		if rem := size - written; rem < n {
+			n = rem
+		}
+		bufOff := written + 1
+
+		wn, err := w.Write(b.readAt(bufOff, n))
+		written += int64(wn)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// BufferReader is an independently positioned view over a Buffer's content.
+// It shares the underlying storage with the Buffer it was created from (and
+// with any other BufferReader over the same Buffer), so many readers can
+// stream the same Buffer concurrently from different offsets.
+type BufferReader struct {
+	b   *Buffer
+	pos int64
+}
+
+// NewReader returns a BufferReader positioned at the start of b's content.
+func (b *Buffer) NewReader() *BufferReader {
+	return &BufferReader{b: b}
+}
+
+func (r *BufferReader) Read(p []byte) (int, error) {
+	n, err := r.b.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *BufferReader) Seek(offset int64, whence int) (int64, error) {
+	abs, err := seekAbs(r.pos, r.b.offset-1, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+// seekAbs resolves an io.Seeker call against a cursor over size bytes of
+// content. Negative results are an error; positions at or past size are
+// allowed, and a read from there returns io.EOF.
+func seekAbs(cur, size, offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = cur + offset
+	case io.SeekEnd:
+		abs = size + offset
+	default:
+		return 0, errors.Errorf("z: Seek: invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.Errorf("z: Seek: negative position %d", abs)
+	}
+	return abs, nil
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func newTestBufferWithContent(t *testing.T, content string) *Buffer {
+	t.Helper()
+	b := NewBuffer(int64(len(content)))
+	if _, err := b.Write([]byte(content)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return b
+}
+
+func TestReadAt(t *testing.T) {
+	b := newTestBufferWithContent(t, "hello world")
+	defer b.Release()
+
+	got := make([]byte, 5)
+	n, err := b.ReadAt(got, 6)
+	if err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if n != 5 || string(got) != "world" {
+		t.Fatalf("ReadAt(6) = %q (n=%d), want %q (n=5)", got, n, "world")
+	}
+}
+
+func TestReadAtEOF(t *testing.T) {
+	b := newTestBufferWithContent(t, "hello")
+	defer b.Release()
+
+	got := make([]byte, 10)
+	n, err := b.ReadAt(got, 3)
+	if err != io.EOF {
+		t.Fatalf("ReadAt past the end: err = %v, want io.EOF", err)
+	}
+	if n != 2 || string(got[:n]) != "lo" {
+		t.Fatalf("ReadAt past the end = %q (n=%d), want %q (n=2)", got[:n], n, "lo")
+	}
+
+	// An offset exactly at or past the written size is a clean EOF with no
+	// bytes read.
+	n, err = b.ReadAt(got, 5)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("ReadAt(at size) = %q (n=%d, err=%v), want 0 bytes and io.EOF", got[:n], n, err)
+	}
+}
+
+func TestReadAtNegativeOffset(t *testing.T) {
+	b := newTestBufferWithContent(t, "hello")
+	defer b.Release()
+
+	if _, err := b.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Fatalf("ReadAt(-1) = nil error, want an error")
+	}
+}
+
+func TestReadSequential(t *testing.T) {
+	b := newTestBufferWithContent(t, "hello world")
+	defer b.Release()
+
+	var got bytes.Buffer
+	// Hide Buffer's WriteTo, so io.Copy has to go through Read.
+	n, err := io.Copy(&got, struct{ io.Reader }{b})
+	if err != nil {
+		t.Fatalf("io.Copy via Read: %v", err)
+	}
+	if n != 11 || got.String() != "hello world" {
+		t.Fatalf("Read = %q (n=%d), want %q (n=11)", got.String(), n, "hello world")
+	}
+}
+
+func TestSeek(t *testing.T) {
+	b := newTestBufferWithContent(t, "0123456789")
+	defer b.Release()
+
+	cases := []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+	}{
+		{"start", 3, io.SeekStart, 3},
+		{"current", 2, io.SeekCurrent, 5},
+		{"end", -1, io.SeekEnd, 9},
+	}
+	for _, tc := range cases {
+		abs, err := b.Seek(tc.offset, tc.whence)
+		if err != nil {
+			t.Fatalf("%s: Seek: %v", tc.name, err)
+		}
+		if abs != tc.want {
+			t.Fatalf("%s: Seek returned %d, want %d", tc.name, abs, tc.want)
+		}
+	}
+
+	if _, err := b.Seek(-100, io.SeekStart); err == nil {
+		t.Fatalf("Seek to a negative position = nil error, want an error")
+	}
+	if _, err := b.Seek(0, 99); err == nil {
+		t.Fatalf("Seek with an invalid whence = nil error, want an error")
+	}
+}
+
+func TestSeekThenRead(t *testing.T) {
+	b := newTestBufferWithContent(t, "0123456789")
+	defer b.Release()
+
+	if _, err := b.Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	got := make([]byte, 3)
+	n, err := b.Read(got)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n != 3 || string(got) != "567" {
+		t.Fatalf("Read after Seek(5) = %q (n=%d), want %q", got, n, "567")
+	}
+}
+
+func TestWriteToMultiChunk(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), writeToChunkSize*2+17)
+	b := NewBuffer(int64(len(content)))
+	defer b.Release()
+	if _, err := b.Write(content); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := b.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("WriteTo wrote %d bytes, want %d", n, len(content))
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Fatalf("WriteTo content mismatch")
+	}
+}
+
+func TestBufferReadersAreIndependent(t *testing.T) {
+	b := newTestBufferWithContent(t, "hello world")
+	defer b.Release()
+
+	r1 := b.NewReader()
+	r2 := b.NewReader()
+
+	buf1 := make([]byte, 5)
+	if _, err := io.ReadFull(r1, buf1); err != nil {
+		t.Fatalf("r1 Read: %v", err)
+	}
+	if string(buf1) != "hello" {
+		t.Fatalf("r1 read %q, want %q", buf1, "hello")
+	}
+
+	// r2 hasn't been advanced, so it should still read from the start,
+	// independently of r1's position.
+	buf2 := make([]byte, 5)
+	if _, err := io.ReadFull(r2, buf2); err != nil {
+		t.Fatalf("r2 Read: %v", err)
+	}
+	if string(buf2) != "hello" {
+		t.Fatalf("r2 read %q, want %q", buf2, "hello")
+	}
+
+	if _, err := r2.Seek(1, io.SeekCurrent); err != nil {
+		t.Fatalf("r2 Seek: %v", err)
+	}
+	rest := make([]byte, 5)
+	n, err := r2.Read(rest)
+	if err != nil {
+		t.Fatalf("r2 Read after Seek: %v", err)
+	}
+	if string(rest[:n]) != "world" {
+		t.Fatalf("r2 read %q after seeking past the space, want %q", rest[:n], "world")
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+)
+
+// scalableBloomR is the factor the target false-positive rate is tightened
+// by on every growth, so that the compounded error rate across all inner
+// filters stays bounded. 0.9 is the value used by the original
+// scalable-Bloom-filter paper.
+const scalableBloomR = 0.9
+
+// ScalableBloom grows to keep its false-positive rate bounded past its
+// initial design capacity, by chaining new, larger, tighter Bloom2 filters
+// in behind the original instead of resizing a single fixed filter.
+type ScalableBloom struct {
+	filters  []*Bloom2
+	p        float64 // target false-positive rate for the newest filter.
+	r        float64
+	capacity int // numEntries used to size the next filter; doubles on growth.
+}
+
+// NewScalableBloomFilter returns a ScalableBloom whose first inner filter is
+// sized for numEntries keys at false-positive rate p.
+func NewScalableBloomFilter(numEntries int, p float64) *ScalableBloom {
+	if numEntries < 1 {
+		numEntries = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	return &ScalableBloom{
+		filters:  []*Bloom2{newBloom2(numEntries, p)},
+		p:        p,
+		r:        scalableBloomR,
+		capacity: numEntries,
+	}
+}
+
+// newBloom2 builds a Bloom2 with a bitset sized for numEntries keys at
+// false-positive rate p, bypassing NewBloomFilter2's fixed bitsPerKey.
+func newBloom2(numEntries int, p float64) *Bloom2 {
+	bitsPerKey := int(math.Ceil(-1 * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if bitsPerKey < 1 {
+		bitsPerKey = 1
+	}
+	k := uint32(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+
+	nBits := numEntries * bitsPerKey
+	if nBits < 64 {
+		nBits = 64
+	}
+	nBytes := (nBits + 7) / 8
+
+	bitset := make([]byte, nBytes+1)
+	bitset[nBytes] = uint8(k)
+	return &Bloom2{bitset: bitset}
+}
+
+// Add writes hash to the newest inner filter, growing first if that filter's
+// estimated false-positive rate has climbed past its target.
+func (s *ScalableBloom) Add(hash uint32) {
+	newest := s.filters[len(s.filters)-1]
+	if estimatedFPRate(newest) > s.p {
+		s.grow()
+		newest = s.filters[len(s.filters)-1]
+	}
+	setBits(newest, hash)
+}
+
+// setBits sets hash's k bit positions directly within bl's already-sized
+// bitset, using the same double-hashing scheme as Bloom2.Has. Bloom2.Add
+// can't be reused here: it extends the bitset on every call by a new block
+// sized for the hashes passed in (at least 64 bits plus a k byte), and
+// recomputes bit positions over the whole, longer bitset, which would defeat
+// newBloom2's up-front
+// sizing and silently drop every key set before the most recent Add.
+func setBits(bl *Bloom2, hash uint32) {
+	f := bl.bitset
+	if len(f) < 2 {
+		return
+	}
+	k := f[len(f)-1]
+	if k == 0 || k > 30 {
+		return
+	}
+	nBits := uint32(8 * (len(f) - 1))
+	delta := hash>>17 | hash<<15
+	for j := uint8(0); j < k; j++ {
+		bitPos := hash % nBits
+		f[bitPos/8] |= 1 << (bitPos % 8)
+		hash += delta
+	}
+}
+
+// estimatedFPRate approximates a Bloom2's current false-positive rate as
+// fillRatio^k: the probability that all k of a non-member's bit positions
+// happen to already be set by other keys. This is the same approximation
+// classically used to size a Bloom filter's bitsPerKey in the first place,
+// so it stays comparable to the target rate ScalableBloom was built with,
+// unlike raw fill ratio (which trends toward ~50% at normal design load
+// regardless of the target false-positive rate).
+func estimatedFPRate(bl *Bloom2) float64 {
+	f := bl.bitset
+	if len(f) < 2 {
+		return 0
+	}
+	k := f[len(f)-1]
+	if k == 0 || k > 30 {
+		return 0
+	}
+	return math.Pow(bl.EstimatedFillRatio(), float64(k))
+}
+
+func (s *ScalableBloom) grow() {
+	s.capacity *= 2
+	s.p *= s.r
+	s.filters = append(s.filters, newBloom2(s.capacity, s.p))
+}
+
+// Has returns true if hash matches in any of the inner filters.
+func (s *ScalableBloom) Has(hash uint32) bool {
+	for _, f := range s.filters {
+		if f.Has(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ScalableBloom) AddIfNotHas(hash uint32) bool {
+	if s.Has(hash) {
+		return false
+	}
+	s.Add(hash)
+	return true
+}
+
+// EstimatedFillRatio returns the newest inner filter's fill ratio. Add's
+// growth check is derived from it (see estimatedFPRate).
+func (s *ScalableBloom) EstimatedFillRatio() float64 {
+	return s.filters[len(s.filters)-1].EstimatedFillRatio()
+}
+
+// EstimatedCount returns the summed estimated key count across all inner
+// filters.
+func (s *ScalableBloom) EstimatedCount() int {
+	total := 0
+	for _, f := range s.filters {
+		total += f.EstimatedCount()
+	}
+	return total
+}
+
+type scalableBloomJSON struct {
+	Filters  [][]byte
+	P        float64
+	R        float64
+	Capacity int
+}
+
+func (s *ScalableBloom) JSONMarshal() []byte {
+	sj := scalableBloomJSON{P: s.p, R: s.r, Capacity: s.capacity}
+	for _, f := range s.filters {
+		sj.Filters = append(sj.Filters, f.bitset)
+	}
+	data, err := json.Marshal(sj)
+	if err != nil {
+		log.Fatal("json.Marshal failed: ", err)
+	}
+	return data
+}
+
+func JSONUnmarshalScalableBloom(data []byte) (*ScalableBloom, error) {
+	var sj scalableBloomJSON
+	if err := json.Unmarshal(data, &sj); err != nil {
+		return nil, err
+	}
+	s := &ScalableBloom{p: sj.P, r: sj.R, capacity: sj.Capacity}
+	for _, bs := range sj.Filters {
+		s.filters = append(s.filters, &Bloom2{bitset: bs})
+	}
+	return s, nil
+}
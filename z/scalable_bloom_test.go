@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import "testing"
+
+func TestEstimatedFPRateStartsNearZero(t *testing.T) {
+	bl := newBloom2(1000, 0.01)
+	if got := estimatedFPRate(bl); got > 0.0001 {
+		t.Fatalf("expected an empty filter's estimated FP rate to be near zero, got %v", got)
+	}
+}
+
+// TestScalableBloomDoesNotGrowPrematurely is a regression test: Add used to
+// compare raw fill ratio against the target false-positive rate directly,
+// which made a filter sized for 1000 entries at p=0.01 grow after only 200
+// inserts.
+func TestScalableBloomDoesNotGrowPrematurely(t *testing.T) {
+	s := NewScalableBloomFilter(1000, 0.01)
+	for i := 0; i < 200; i++ {
+		s.Add(uint32(i) * 2654435761)
+	}
+	if got := len(s.filters); got != 1 {
+		t.Fatalf("expected no growth after 200/1000 inserts, got %d inner filters", got)
+	}
+}
+
+func TestScalableBloomGrowsNearCapacity(t *testing.T) {
+	s := NewScalableBloomFilter(100, 0.01)
+	for i := 0; i < 5000; i++ {
+		s.Add(uint32(i) * 2654435761)
+	}
+	if got := len(s.filters); got <= 1 {
+		t.Fatalf("expected ScalableBloom to have grown past its initial capacity, got %d inner filters", got)
+	}
+}
+
+func TestScalableBloomHasAndJSONRoundTrip(t *testing.T) {
+	s := NewScalableBloomFilter(100, 0.01)
+	var added []uint32
+	for i := uint32(0); i < 500; i += 7 {
+		s.Add(i)
+		added = append(added, i)
+	}
+
+	for _, h := range added {
+		if !s.Has(h) {
+			t.Fatalf("Has(%d) = false, want true", h)
+		}
+	}
+
+	data := s.JSONMarshal()
+	got, err := JSONUnmarshalScalableBloom(data)
+	if err != nil {
+		t.Fatalf("JSONUnmarshalScalableBloom: %v", err)
+	}
+	for _, h := range added {
+		if !got.Has(h) {
+			t.Fatalf("after round-trip, Has(%d) = false, want true", h)
+		}
+	}
+}
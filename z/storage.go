@@ -0,0 +1,97 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// BufferStorage abstracts over the backing store a UseMmap Buffer spills
+// into. The default is TempFileStorage (a temp file on disk); swapping in a
+// different implementation, e.g. MemfdStorage, doesn't require touching
+// Buffer's growth or compression logic.
+type BufferStorage interface {
+	// Truncate grows (or shrinks) the backing store to size bytes.
+	Truncate(size int64) error
+	// MapRegion maps the [off, off+length) range of the backing store into
+	// memory and returns it. Buffer only ever maps a single region, from 0
+	// up to maxSz, once, up front.
+	MapRegion(off, length int64) ([]byte, error)
+	// Unmap releases a region returned by MapRegion.
+	Unmap(region []byte) error
+	// Close releases the backing store entirely. Unmap must be called for
+	// any outstanding region first.
+	Close() error
+}
+
+// TempFileStorage is the default BufferStorage: a temp file on disk, mmap'd
+// in. This is the behavior Buffer has always had in UseMmap mode.
+type TempFileStorage struct {
+	fd *os.File
+}
+
+// NewTempFileStorage creates a TempFileStorage backed by a new temp file.
+func NewTempFileStorage() (*TempFileStorage, error) {
+	fd, err := ioutil.TempFile("", "buffer")
+	if err != nil {
+		return nil, err
+	}
+	return &TempFileStorage{fd: fd}, nil
+}
+
+func (s *TempFileStorage) Truncate(size int64) error {
+	if err := s.fd.Truncate(size); err != nil {
+		return errors.Wrapf(err, "while truncating %s to size: %d", s.fd.Name(), size)
+	}
+	return nil
+}
+
+func (s *TempFileStorage) MapRegion(off, length int64) ([]byte, error) {
+	if off != 0 {
+		return nil, errors.Errorf("z: TempFileStorage only supports mapping from offset 0")
+	}
+	buf, err := Mmap(s.fd, true, length)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while mmapping %s with size: %d", s.fd.Name(), length)
+	}
+	return buf, nil
+}
+
+func (s *TempFileStorage) Unmap(region []byte) error {
+	return Munmap(region)
+}
+
+func (s *TempFileStorage) Close() error {
+	fname := s.fd.Name()
+	if err := s.fd.Truncate(0); err != nil {
+		return errors.Wrapf(err, "while truncating file %s", fname)
+	}
+	if err := s.fd.Close(); err != nil {
+		return errors.Wrapf(err, "while closing file %s", fname)
+	}
+	if err := os.Remove(fname); err != nil {
+		return errors.Wrapf(err, "while deleting file %s", fname)
+	}
+	return nil
+}
+
+// File returns the temp file backing s, for callers that need direct access
+// to it (e.g. to Sync it).
+func (s *TempFileStorage) File() *os.File { return s.fd }
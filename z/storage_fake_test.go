@@ -0,0 +1,49 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+// memStorage is a BufferStorage backed by a plain Go slice, used by tests so
+// they don't depend on a real mmap or a writable filesystem.
+type memStorage struct {
+	buf    []byte
+	closed bool
+}
+
+func (s *memStorage) Truncate(size int64) error {
+	if int64(len(s.buf)) < size {
+		grown := make([]byte, size)
+		copy(grown, s.buf)
+		s.buf = grown
+	}
+	return nil
+}
+
+func (s *memStorage) MapRegion(off, length int64) ([]byte, error) {
+	if err := s.Truncate(off + length); err != nil {
+		return nil, err
+	}
+	return s.buf[off : off+length], nil
+}
+
+func (s *memStorage) Unmap(region []byte) error {
+	return nil
+}
+
+func (s *memStorage) Close() error {
+	s.closed = true
+	return nil
+}